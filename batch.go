@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// embedRequest is one caller's text waiting to be folded into a batch.
+type embedRequest struct {
+	text       string
+	model      string // model active when this request was enqueued; see EmbedContext
+	ctx        context.Context
+	enqueuedAt time.Time
+	result     chan embedResult
+}
+
+type embedResult struct {
+	vector []float64
+	model  string // model Python actually computed vector under; see dispatchBatch
+	err    error
+}
+
+// BatchStats summarizes the micro-batcher's recent behavior for operators
+// tuning MaxBatchSize / MaxBatchWait.
+type BatchStats struct {
+	TotalBatches int64   `json:"total_batches"`
+	TotalItems   int64   `json:"total_items"`
+	AvgBatchSize float64 `json:"avg_batch_size"`
+	AvgWaitMs    float64 `json:"avg_wait_ms"`
+	QueueDepth   int64   `json:"queue_depth"`
+}
+
+// BatchStats reports the micro-batcher's cumulative stats.
+func (c *EmbedClient) BatchStats() BatchStats {
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+
+	stats := BatchStats{
+		TotalBatches: c.totalBatches,
+		TotalItems:   c.totalItems,
+		QueueDepth:   atomic.LoadInt64(&c.queueDepth),
+	}
+	if c.totalBatches > 0 {
+		stats.AvgBatchSize = float64(c.totalItems) / float64(c.totalBatches)
+		stats.AvgWaitMs = float64(c.totalWaitNs) / float64(c.totalBatches) / float64(time.Millisecond)
+	}
+	return stats
+}
+
+// Embed generates embeddings for the given texts. Each text is enqueued
+// individually and scattered across whatever batch the background batchLoop
+// assembles it into, but results are returned in the same order as texts.
+func (c *EmbedClient) Embed(texts []string) ([][]float64, error) {
+	return c.EmbedContext(context.Background(), texts)
+}
+
+// EmbedContext is Embed with cancellation: if ctx is done before a text's
+// result arrives, EmbedContext returns early with ctx.Err(). Note that a
+// text already folded into a dispatched batch can't be un-sent without
+// affecting the other callers sharing that batch, so cancellation here is
+// best-effort on the caller's side of the queue.
+//
+// If caching is enabled (see cache.go), texts already embedded under the
+// current model are served straight from the cache and only the misses are
+// sent through the batching queue.
+func (c *EmbedClient) EmbedContext(ctx context.Context, texts []string) ([][]float64, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+
+	out := make([][]float64, len(texts))
+	misses := make([]int, 0, len(texts))
+	for i, text := range texts {
+		if c.cache != nil {
+			if vec, ok := c.cache.Get(c.model, text); ok {
+				out[i] = vec
+				continue
+			}
+		}
+		misses = append(misses, i)
+	}
+	if len(misses) == 0 {
+		return out, nil
+	}
+
+	channels := make([]chan embedResult, len(misses))
+	now := time.Now()
+	for j, i := range misses {
+		ch := make(chan embedResult, 1)
+		channels[j] = ch
+		req := &embedRequest{text: texts[i], model: c.model, ctx: ctx, enqueuedAt: now, result: ch}
+		select {
+		case c.queue <- req:
+			queueDepthGauge.Set(float64(atomic.AddInt64(&c.queueDepth, 1)))
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	for j, ch := range channels {
+		i := misses[j]
+		select {
+		case res := <-ch:
+			if res.err != nil {
+				return nil, res.err
+			}
+			out[i] = res.vector
+			// Keyed on res.model (the model Python actually reported
+			// computing this vector under), not the live c.model field:
+			// /model and /v1/embeddings can switch models concurrently with
+			// this call, and by the time the result lands c.model may no
+			// longer match what the vector was actually computed with.
+			if c.cache != nil && res.model != "" {
+				c.cache.Put(res.model, texts[i], res.vector)
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return out, nil
+}
+
+// batchLoop drains c.queue into batches of up to maxBatchSize, waiting at
+// most maxBatchWait for a batch to fill before dispatching what it has.
+func (c *EmbedClient) batchLoop() {
+	for first := range c.queue {
+		batch := make([]*embedRequest, 1, c.maxBatchSize)
+		batch[0] = first
+
+		timer := time.NewTimer(c.maxBatchWait)
+	fill:
+		for len(batch) < c.maxBatchSize {
+			select {
+			case req := <-c.queue:
+				batch = append(batch, req)
+			case <-timer.C:
+				break fill
+			}
+		}
+		timer.Stop()
+
+		c.dispatchBatch(batch)
+	}
+}
+
+// dispatchBatch issues one "embed" call to Python for the whole batch and
+// scatters the resulting vectors back to each caller by index. Requests
+// whose context is already done are dropped before ever hitting the wire.
+//
+// The physical call itself is never bounded by any one live caller's
+// deadline: doing so would cancel the embeddings for every other caller
+// sharing the batch just because one of them timed out. Instead each live
+// caller is watched independently while the call is in flight (see
+// watchCancellations), and only gets its own ctx.Err() delivered early; the
+// shared call is only aborted once every caller watching it has gone.
+func (c *EmbedClient) dispatchBatch(batch []*embedRequest) {
+	live := make([]*embedRequest, 0, len(batch))
+	for _, req := range batch {
+		select {
+		case <-req.ctx.Done():
+			req.result <- embedResult{err: req.ctx.Err()}
+		default:
+			live = append(live, req)
+		}
+	}
+	queueDepthGauge.Set(float64(atomic.AddInt64(&c.queueDepth, -int64(len(batch)))))
+	if len(live) == 0 {
+		return
+	}
+
+	texts := make([]string, len(live))
+	oldestWait := time.Duration(0)
+	for i, req := range live {
+		texts[i] = req.text
+		if wait := time.Since(req.enqueuedAt); wait > oldestWait {
+			oldestWait = wait
+		}
+	}
+
+	callCtx, cancelCall := context.WithCancel(context.Background())
+	defer cancelCall()
+	delivered := watchCancellations(callCtx, cancelCall, live)
+
+	resp, err := c.call(callCtx, map[string]interface{}{"action": "embed", "texts": texts})
+
+	batchSizeHistogram.Observe(float64(len(live)))
+	textsTotal.Add(float64(len(live)))
+
+	c.statsMu.Lock()
+	c.totalBatches++
+	c.totalItems += int64(len(live))
+	c.totalWaitNs += int64(oldestWait)
+	c.statsMu.Unlock()
+
+	for i, req := range live {
+		if !atomic.CompareAndSwapInt32(&delivered[i], 0, 1) {
+			continue // already got its own ctx.Err() from watchCancellations
+		}
+		if err != nil {
+			req.result <- embedResult{err: err}
+			continue
+		}
+		if req.model != "" && resp.Model != "" && req.model != resp.Model {
+			log.Printf("embed: model switched from %s to %s between enqueue and dispatch", req.model, resp.Model)
+		}
+		req.result <- embedResult{vector: resp.Embeddings[i], model: resp.Model}
+	}
+}
+
+// watchCancellations spawns one goroutine per live request watching for its
+// ctx to finish before callCtx does. A request whose ctx finishes first gets
+// its own ctx.Err() delivered immediately, independent of the rest of the
+// batch; once every live request has either finished early or the call
+// itself completes, cancelCall is invoked to stop watching (or, if every
+// caller has already gone, to stop a physical call nobody is left to read).
+// The returned slice uses 0/1 as a per-index "already delivered" flag so the
+// caller can skip requests watchCancellations already resolved.
+func watchCancellations(callCtx context.Context, cancelCall context.CancelFunc, live []*embedRequest) []int32 {
+	delivered := make([]int32, len(live))
+	remaining := int32(len(live))
+	for i, req := range live {
+		go func(i int, req *embedRequest) {
+			select {
+			case <-req.ctx.Done():
+				if atomic.CompareAndSwapInt32(&delivered[i], 0, 1) {
+					req.result <- embedResult{err: req.ctx.Err()}
+					if atomic.AddInt32(&remaining, -1) == 0 {
+						cancelCall()
+					}
+				}
+			case <-callCtx.Done():
+			}
+		}(i, req)
+	}
+	return delivered
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+)
+
+// newTestClient wires an EmbedClient to an in-memory pipe standing in for
+// the Python subprocess, so the batching/cancellation logic in batch.go and
+// rpc.go can be exercised without jumpboot or a real interpreter. handle is
+// invoked once per command the Go side sends and returns the JSON fields to
+// echo back (the "id" field is filled in automatically).
+func newTestClient(t *testing.T, maxBatchSize int, maxBatchWait time.Duration, handle func(cmd map[string]interface{}) map[string]interface{}) *EmbedClient {
+	t.Helper()
+
+	cmdR, cmdW := io.Pipe()
+	respR, respW := io.Pipe()
+
+	c := &EmbedClient{
+		reader:       bufio.NewReader(respR),
+		writer:       cmdW,
+		queue:        make(chan *embedRequest, maxBatchSize*4),
+		maxBatchSize: maxBatchSize,
+		maxBatchWait: maxBatchWait,
+		pending:      make(map[uint64]chan *EmbedResponse),
+		model:        "test-model",
+	}
+
+	go func() {
+		scanner := bufio.NewScanner(cmdR)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+		for scanner.Scan() {
+			var cmd map[string]interface{}
+			if err := json.Unmarshal(scanner.Bytes(), &cmd); err != nil {
+				continue
+			}
+			resp := handle(cmd)
+			if resp == nil {
+				resp = map[string]interface{}{}
+			}
+			resp["id"] = cmd["id"]
+			out, _ := json.Marshal(resp)
+			if _, err := respW.Write(append(out, '\n')); err != nil {
+				return
+			}
+		}
+	}()
+
+	go c.batchLoop()
+	go c.readLoop()
+
+	t.Cleanup(func() {
+		cmdR.Close()
+		cmdW.Close()
+		respR.Close()
+		respW.Close()
+	})
+
+	return c
+}
+
+// echoEmbed returns a handle func that answers "embed" commands with one
+// float per text, equal to the text's length, so tests can check both
+// ordering and per-caller isolation without needing real embeddings.
+func echoEmbed(perCallDelay time.Duration) func(cmd map[string]interface{}) map[string]interface{} {
+	return func(cmd map[string]interface{}) map[string]interface{} {
+		if cmd["action"] != "embed" {
+			return map[string]interface{}{"error": "unsupported action in test fake"}
+		}
+		if perCallDelay > 0 {
+			time.Sleep(perCallDelay)
+		}
+		texts, _ := cmd["texts"].([]interface{})
+		vectors := make([][]float64, len(texts))
+		for i, t := range texts {
+			s, _ := t.(string)
+			vectors[i] = []float64{float64(len(s))}
+		}
+		return map[string]interface{}{"embeddings": vectors, "model": "test-model", "dimension": 1}
+	}
+}
+
+// TestEmbedContextCoalescesIntoBatches checks that several concurrent
+// EmbedContext calls, small enough to fit in one maxBatchSize window, are
+// folded into a single physical call and each still gets back its own
+// correct embedding in order.
+func TestEmbedContextCoalescesIntoBatches(t *testing.T) {
+	c := newTestClient(t, 8, 20*time.Millisecond, echoEmbed(0))
+
+	texts := []string{"a", "bb", "ccc", "dddd"}
+	results := make([][][]float64, len(texts))
+	errs := make([]error, len(texts))
+	done := make(chan int, len(texts))
+
+	for i, text := range texts {
+		go func(i int, text string) {
+			vecs, err := c.EmbedContext(context.Background(), []string{text})
+			results[i] = vecs
+			errs[i] = err
+			done <- i
+		}(i, text)
+	}
+	for range texts {
+		<-done
+	}
+
+	for i, text := range texts {
+		if errs[i] != nil {
+			t.Fatalf("text %q: unexpected error: %v", text, errs[i])
+		}
+		if got := results[i][0][0]; got != float64(len(text)) {
+			t.Fatalf("text %q: got embedding %v, want [%d]", text, results[i][0], len(text))
+		}
+	}
+
+	stats := c.BatchStats()
+	if stats.TotalBatches == 0 {
+		t.Fatalf("expected at least one dispatched batch")
+	}
+	if stats.TotalItems != int64(len(texts)) {
+		t.Fatalf("expected %d items across batches, got %d", len(texts), stats.TotalItems)
+	}
+	// The whole point of the micro-batcher is coalescing: these requests were
+	// all enqueued together well within maxBatchWait, so they must not have
+	// been dispatched one batch per request.
+	if stats.TotalBatches >= int64(len(texts)) {
+		t.Fatalf("expected concurrent requests to coalesce into fewer than %d batches, got %d", len(texts), stats.TotalBatches)
+	}
+}
+
+// TestDispatchBatchIsolatesPerCallerCancellation folds a short-deadline
+// caller and a long-lived caller into the same physical batch, behind a fake
+// Python worker slow enough that the short deadline fires mid-call. The
+// short-deadline caller must see its own context error; the other caller
+// must still receive its correct embedding rather than being dragged down
+// by its batch-mate's timeout.
+func TestDispatchBatchIsolatesPerCallerCancellation(t *testing.T) {
+	c := newTestClient(t, 2, time.Hour, echoEmbed(100*time.Millisecond))
+
+	shortCtx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	type outcome struct {
+		vecs [][]float64
+		err  error
+	}
+	shortDone := make(chan outcome, 1)
+	longDone := make(chan outcome, 1)
+
+	go func() {
+		vecs, err := c.EmbedContext(shortCtx, []string{"short"})
+		shortDone <- outcome{vecs, err}
+	}()
+	go func() {
+		// Give the short-deadline caller a head start into the queue so both
+		// land in the same batch (batchLoop fills up to maxBatchSize=2).
+		time.Sleep(2 * time.Millisecond)
+		vecs, err := c.EmbedContext(context.Background(), []string{"longlived"})
+		longDone <- outcome{vecs, err}
+	}()
+
+	short := <-shortDone
+	if short.err == nil {
+		t.Fatalf("expected short-deadline caller to get a context error, got result %v", short.vecs)
+	}
+
+	long := <-longDone
+	if long.err != nil {
+		t.Fatalf("batch-mate with no deadline should not be affected by the other caller's timeout, got error: %v", long.err)
+	}
+	if got := long.vecs[0][0]; got != float64(len("longlived")) {
+		t.Fatalf("batch-mate got embedding %v, want [%d]", long.vecs, len("longlived"))
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bufio"
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultCacheMaxEntries bounds the on-disk cache when --cache-max-size isn't set.
+const DefaultCacheMaxEntries = 100000
+
+// memLRUCapacity bounds the in-memory hot-key cache sitting in front of disk.
+const memLRUCapacity = 4096
+
+// EmbeddingCache is a content-addressed, on-disk cache for embeddings, keyed
+// by sha256(model || 0x00 || normalized text). Entries are sharded into
+// subdirectories named by the first two hex digits of the key to keep any
+// one directory small. A bounded in-memory LRU sits in front so repeated lookups for hot
+// texts don't round-trip to disk.
+type EmbeddingCache struct {
+	dir        string
+	maxEntries int
+
+	mu  sync.Mutex
+	lru *list.List
+	idx map[string]*list.Element
+
+	entryCount int64 // approx on-disk file count, seeded from disk at startup
+
+	hits   int64
+	misses int64
+}
+
+type cacheEntry struct {
+	key    string
+	model  string
+	vector []float64
+}
+
+// CacheStats summarizes cache effectiveness for `jb-embed cache stats` and
+// the /health endpoint.
+type CacheStats struct {
+	Hits        int64   `json:"hits"`
+	Misses      int64   `json:"misses"`
+	HitRate     float64 `json:"hit_rate"`
+	DiskEntries int64   `json:"disk_entries"`
+}
+
+// NewEmbeddingCache opens (creating if necessary) a content-addressed cache
+// rooted at dir. maxEntries caps the number of on-disk entries; 0 uses
+// DefaultCacheMaxEntries.
+func NewEmbeddingCache(dir string, maxEntries int) (*EmbeddingCache, error) {
+	if maxEntries <= 0 {
+		maxEntries = DefaultCacheMaxEntries
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cache dir: %w", err)
+	}
+
+	c := &EmbeddingCache{
+		dir:        dir,
+		maxEntries: maxEntries,
+		lru:        list.New(),
+		idx:        make(map[string]*list.Element),
+	}
+	c.entryCount = int64(c.walkEntries(func(string, os.FileInfo) {}))
+	return c, nil
+}
+
+// cacheKey computes the content-address for (model, text).
+func cacheKey(model, text string) string {
+	h := sha256.New()
+	h.Write([]byte(model))
+	h.Write([]byte{0})
+	h.Write([]byte(strings.TrimSpace(text)))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *EmbeddingCache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key+".f32")
+}
+
+// Get returns the cached embedding for (model, text), if present.
+func (c *EmbeddingCache) Get(model, text string) ([]float64, bool) {
+	key := cacheKey(model, text)
+
+	c.mu.Lock()
+	if el, ok := c.idx[key]; ok {
+		entry := el.Value.(*cacheEntry)
+		c.lru.MoveToFront(el)
+		c.mu.Unlock()
+		if entry.model == model {
+			c.recordHit()
+			return entry.vector, true
+		}
+		c.recordMiss()
+		return nil, false
+	}
+	c.mu.Unlock()
+
+	vec, gotModel, err := readCacheFile(c.path(key))
+	if err != nil || gotModel != model {
+		c.recordMiss()
+		return nil, false
+	}
+	c.recordHit()
+	c.promote(key, model, vec)
+	return vec, true
+}
+
+// Put writes vec to disk under (model, text) and promotes it into the
+// in-memory LRU, evicting on-disk entries if the cache has grown past
+// maxEntries.
+func (c *EmbeddingCache) Put(model, text string, vec []float64) error {
+	key := cacheKey(model, text)
+	path := c.path(key)
+
+	isNew := true
+	if _, err := os.Stat(path); err == nil {
+		isNew = false
+	}
+	if err := writeCacheFileAtomic(path, model, vec); err != nil {
+		return err
+	}
+	if isNew {
+		atomic.AddInt64(&c.entryCount, 1)
+	}
+	c.promote(key, model, vec)
+	c.evictIfNeeded()
+	return nil
+}
+
+func (c *EmbeddingCache) recordHit() {
+	atomic.AddInt64(&c.hits, 1)
+	cacheHitsTotal.Inc()
+}
+
+func (c *EmbeddingCache) recordMiss() {
+	atomic.AddInt64(&c.misses, 1)
+	cacheMissesTotal.Inc()
+}
+
+func (c *EmbeddingCache) promote(key, model string, vec []float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.idx[key]; ok {
+		el.Value.(*cacheEntry).vector = vec
+		c.lru.MoveToFront(el)
+		return
+	}
+	el := c.lru.PushFront(&cacheEntry{key: key, model: model, vector: vec})
+	c.idx[key] = el
+	if c.lru.Len() > memLRUCapacity {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.idx, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// evictIfNeeded removes the least-recently-modified on-disk entries once the
+// cache has grown past maxEntries. It's called after every Put but returns
+// early unless the count is over the cap, so the O(n) directory walk stays
+// rare.
+func (c *EmbeddingCache) evictIfNeeded() {
+	if atomic.LoadInt64(&c.entryCount) <= int64(c.maxEntries) {
+		return
+	}
+
+	type fileAge struct {
+		path    string
+		modTime int64
+	}
+	var files []fileAge
+	c.walkEntries(func(path string, info os.FileInfo) {
+		files = append(files, fileAge{path: path, modTime: info.ModTime().UnixNano()})
+	})
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime < files[j].modTime })
+
+	overflow := len(files) - c.maxEntries
+	for i := 0; i < overflow && i < len(files); i++ {
+		if err := os.Remove(files[i].path); err == nil {
+			atomic.AddInt64(&c.entryCount, -1)
+		}
+	}
+}
+
+// Purge removes cached entries, optionally restricted to a single model, and
+// returns the number removed.
+func (c *EmbeddingCache) Purge(model string) (int, error) {
+	removed := 0
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".f32") {
+			return nil
+		}
+		if model != "" {
+			_, gotModel, ferr := readCacheFile(path)
+			if ferr != nil || gotModel != model {
+				return nil
+			}
+		}
+		if rmErr := os.Remove(path); rmErr == nil {
+			removed++
+			atomic.AddInt64(&c.entryCount, -1)
+		}
+		return nil
+	})
+	if err != nil {
+		return removed, err
+	}
+
+	c.mu.Lock()
+	for key, el := range c.idx {
+		entry := el.Value.(*cacheEntry)
+		if model == "" || entry.model == model {
+			c.lru.Remove(el)
+			delete(c.idx, key)
+		}
+	}
+	c.mu.Unlock()
+
+	return removed, nil
+}
+
+// Stats reports cumulative hit/miss counts and the current on-disk size.
+func (c *EmbeddingCache) Stats() CacheStats {
+	hits := atomic.LoadInt64(&c.hits)
+	misses := atomic.LoadInt64(&c.misses)
+	stats := CacheStats{Hits: hits, Misses: misses, DiskEntries: atomic.LoadInt64(&c.entryCount)}
+	if total := hits + misses; total > 0 {
+		stats.HitRate = float64(hits) / float64(total)
+	}
+	return stats
+}
+
+// walkEntries visits every on-disk cache file, invoking fn for each, and
+// returns the count visited.
+func (c *EmbeddingCache) walkEntries(fn func(path string, info os.FileInfo)) int {
+	count := 0
+	filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".f32") {
+			return nil
+		}
+		count++
+		if info, ierr := d.Info(); ierr == nil {
+			fn(path, info)
+		}
+		return nil
+	})
+	return count
+}
+
+// runCacheCommand implements the `jb-embed cache stats` / `jb-embed cache
+// purge [--model NAME]` subcommands. modelFilter is "" unless the caller
+// passed --model, in which case purge is restricted to that model.
+func runCacheCommand(dir string, maxEntries int, args []string, modelFilter string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: jb-embed cache <stats|purge> [--model NAME]")
+	}
+
+	cache, err := NewEmbeddingCache(dir, maxEntries)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "stats":
+		out, err := json.MarshalIndent(cache.Stats(), "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	case "purge":
+		n, err := cache.Purge(modelFilter)
+		if err != nil {
+			return fmt.Errorf("failed to purge cache: %w", err)
+		}
+		fmt.Printf("purged %d cache entries\n", n)
+		return nil
+	default:
+		return fmt.Errorf("unknown cache subcommand: %s", args[0])
+	}
+}
+
+// Cache file format: uint32 model name length, model name bytes, uint32
+// vector dimension, then dimension little-endian float32s. The model name
+// and dimension header mean a model switch invalidates cleanly: a lookup
+// under the new model just won't find a matching header.
+func writeCacheFileAtomic(path string, model string, vec []float64) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // no-op once renamed
+
+	w := bufio.NewWriter(tmp)
+	header := make([]byte, 4)
+	binary.LittleEndian.PutUint32(header, uint32(len(model)))
+	if _, err := w.Write(header); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := w.WriteString(model); err != nil {
+		tmp.Close()
+		return err
+	}
+	binary.LittleEndian.PutUint32(header, uint32(len(vec)))
+	if _, err := w.Write(header); err != nil {
+		tmp.Close()
+		return err
+	}
+	buf := make([]byte, 4*len(vec))
+	for i, v := range vec {
+		binary.LittleEndian.PutUint32(buf[i*4:], math.Float32bits(float32(v)))
+	}
+	if _, err := w.Write(buf); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func readCacheFile(path string) ([]float64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, "", err
+	}
+	modelLen := binary.LittleEndian.Uint32(header)
+	modelBytes := make([]byte, modelLen)
+	if _, err := io.ReadFull(r, modelBytes); err != nil {
+		return nil, "", err
+	}
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, "", err
+	}
+	dim := binary.LittleEndian.Uint32(header)
+	buf := make([]byte, 4*dim)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, "", err
+	}
+	vec := make([]float64, dim)
+	for i := range vec {
+		vec[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[i*4:])))
+	}
+	return vec, string(modelBytes), nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEmbeddingCachePutGet(t *testing.T) {
+	cache, err := NewEmbeddingCache(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("NewEmbeddingCache: %v", err)
+	}
+
+	if _, ok := cache.Get("model-a", "hello"); ok {
+		t.Fatalf("expected miss on empty cache")
+	}
+
+	vec := []float64{0.1, 0.2, 0.3}
+	if err := cache.Put("model-a", "hello", vec); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	got, ok := cache.Get("model-a", "hello")
+	if !ok {
+		t.Fatalf("expected hit after Put")
+	}
+	if len(got) != len(vec) {
+		t.Fatalf("got vector %v, want %v", got, vec)
+	}
+	for i := range vec {
+		if float32(got[i]) != float32(vec[i]) {
+			t.Fatalf("got vector %v, want %v", got, vec)
+		}
+	}
+
+	// Same text under a different model must not hit model-a's entry.
+	if _, ok := cache.Get("model-b", "hello"); ok {
+		t.Fatalf("expected miss: entry is scoped to model-a, not model-b")
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 1 || stats.Misses != 2 {
+		t.Fatalf("got stats %+v, want 1 hit, 2 misses", stats)
+	}
+}
+
+func TestEmbeddingCacheEviction(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewEmbeddingCache(dir, 3)
+	if err != nil {
+		t.Fatalf("NewEmbeddingCache: %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		text := string(rune('a' + i))
+		if err := cache.Put("model-a", text, []float64{float64(i)}); err != nil {
+			t.Fatalf("Put %q: %v", text, err)
+		}
+		// Cache eviction orders by on-disk mtime; give each write a distinct
+		// timestamp so the oldest-first eviction order is deterministic.
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stats := cache.Stats()
+	if stats.DiskEntries != 3 {
+		t.Fatalf("got %d disk entries, want 3 (maxEntries)", stats.DiskEntries)
+	}
+
+	// Reopen against the same directory to bypass the in-memory LRU (which
+	// still holds evicted keys) and confirm eviction actually happened on
+	// disk: the two oldest entries ("a", "b") should be gone, the three
+	// newest ("c", "d", "e") should remain.
+	reopened, err := NewEmbeddingCache(dir, 3)
+	if err != nil {
+		t.Fatalf("NewEmbeddingCache (reopen): %v", err)
+	}
+	if _, ok := reopened.Get("model-a", "a"); ok {
+		t.Fatalf("expected oldest entry to be evicted")
+	}
+	if _, ok := reopened.Get("model-a", "e"); !ok {
+		t.Fatalf("expected newest entry to survive eviction")
+	}
+}
+
+func TestEmbeddingCachePurge(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewEmbeddingCache(dir, 0)
+	if err != nil {
+		t.Fatalf("NewEmbeddingCache: %v", err)
+	}
+
+	cache.Put("model-a", "x", []float64{1})
+	cache.Put("model-a", "y", []float64{2})
+	cache.Put("model-b", "x", []float64{3})
+
+	n, err := cache.Purge("model-a")
+	if err != nil {
+		t.Fatalf("Purge(model-a): %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("purged %d entries, want 2", n)
+	}
+	if _, ok := cache.Get("model-a", "x"); ok {
+		t.Fatalf("expected model-a entries to be gone after purge")
+	}
+	if _, ok := cache.Get("model-b", "x"); !ok {
+		t.Fatalf("purge scoped to model-a should not remove model-b's entry")
+	}
+
+	n, err = cache.Purge("")
+	if err != nil {
+		t.Fatalf("Purge(\"\"): %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("purged %d entries, want 1 (remaining model-b entry)", n)
+	}
+	if stats := cache.Stats(); stats.DiskEntries != 0 {
+		t.Fatalf("got %d disk entries after full purge, want 0", stats.DiskEntries)
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/richinsley/jumpboot"
+)
+
+//go:embed embed.py
+var embedScript string
+
+// DefaultMaxBatchSize and DefaultMaxBatchWait are the micro-batching knobs
+// used when NewEmbedClient is given a non-positive value for either.
+const (
+	DefaultMaxBatchSize = 64
+	DefaultMaxBatchWait = 5 * time.Millisecond
+)
+
+// EmbedClient manages a Python embedding process
+type EmbedClient struct {
+	process *jumpboot.PythonProcess
+	reader  *bufio.Reader
+	writer  io.Writer
+	mu      sync.Mutex
+	model   string
+	dim     int
+
+	// Micro-batching: Embed enqueues onto queue and a single background
+	// goroutine (batchLoop) drains it into batched Python calls. See batch.go.
+	queue        chan *embedRequest
+	maxBatchSize int
+	maxBatchWait time.Duration
+	queueDepth   int64
+
+	statsMu      sync.Mutex
+	totalBatches int64
+	totalItems   int64
+	totalWaitNs  int64
+
+	// Request/response multiplexing: every command is tagged with a
+	// monotonically increasing id, and a single readLoop goroutine (see
+	// rpc.go) delivers each response to the pending call waiting on it.
+	// This lets EmbedContext/LoadModelContext/InfoContext cancel their
+	// own call without disturbing others in flight.
+	nextID    uint64
+	pendingMu sync.Mutex
+	pending   map[uint64]chan *EmbedResponse
+
+	// env and program are kept so a stuck subprocess can be restarted
+	// in place; see restart() in rpc.go.
+	env     *jumpboot.PythonEnvironment
+	program *jumpboot.PythonProgram
+
+	// cache is the optional on-disk embedding cache sitting in front of the
+	// queue; see cache.go. Nil disables caching.
+	cache *EmbeddingCache
+
+	// rerankModel is the sentence-transformers CrossEncoder name sent with
+	// "rerank" calls; "" lets the Python side fall back to its own default.
+	rerankModel string
+}
+
+// EmbedResponse from Python
+type EmbedResponse struct {
+	ID         uint64      `json:"id,omitempty"`
+	Embeddings [][]float64 `json:"embeddings,omitempty"`
+	Model      string      `json:"model,omitempty"`
+	Dimension  int         `json:"dimension,omitempty"`
+	Status     string      `json:"status,omitempty"`
+	Ready      bool        `json:"ready,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	Hits       []RerankHit `json:"hits,omitempty"`
+}
+
+// RerankHit is one scored document returned by Rerank/RerankContext, in the
+// original docs slice's index space.
+type RerankHit struct {
+	Index int     `json:"index"`
+	Score float64 `json:"score"`
+}
+
+// NewEmbedClient creates a new embedding client with a Python process.
+// maxBatchSize and maxBatchWait configure the micro-batcher; pass 0 for
+// either to use DefaultMaxBatchSize / DefaultMaxBatchWait. cacheDir enables
+// the on-disk embedding cache (see cache.go) rooted at that path; pass ""
+// to disable caching entirely.
+func NewEmbedClient(envPath string, pythonVersion string, modelName string, maxBatchSize int, maxBatchWait time.Duration, cacheDir string, cacheMaxEntries int) (*EmbedClient, error) {
+	// Create or use existing environment
+	env, err := jumpboot.CreateEnvironmentMamba("jb-embed", envPath, pythonVersion, "conda-forge", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create environment: %w", err)
+	}
+
+	// Install dependencies if new environment
+	if env.IsNew {
+		fmt.Println("Installing sentence-transformers (first run, may take a minute)...")
+		err = env.PipInstallPackages([]string{"sentence-transformers", "torch"}, "", "", false, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to install packages: %w", err)
+		}
+	}
+
+	// Create program with embedded script
+	cwd, _ := os.Getwd()
+	program := &jumpboot.PythonProgram{
+		Name: "jb-embed",
+		Path: cwd,
+		Program: jumpboot.Module{
+			Name:   "__main__",
+			Path:   filepath.Join(cwd, "embed.py"),
+			Source: base64.StdEncoding.EncodeToString([]byte(embedScript)),
+		},
+	}
+
+	// Start Python process
+	process, _, err := env.NewPythonProcessFromProgram(program, nil, nil, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start Python process: %w", err)
+	}
+
+	if maxBatchSize <= 0 {
+		maxBatchSize = DefaultMaxBatchSize
+	}
+	if maxBatchWait <= 0 {
+		maxBatchWait = DefaultMaxBatchWait
+	}
+
+	client := &EmbedClient{
+		process:      process,
+		reader:       bufio.NewReader(process.PipeIn),
+		writer:       process.PipeOut,
+		queue:        make(chan *embedRequest, maxBatchSize*4),
+		maxBatchSize: maxBatchSize,
+		maxBatchWait: maxBatchWait,
+		pending:      make(map[uint64]chan *EmbedResponse),
+		env:          env,
+		program:      program,
+	}
+
+	if cacheDir != "" {
+		cache, err := NewEmbeddingCache(cacheDir, cacheMaxEntries)
+		if err != nil {
+			process.Terminate()
+			return nil, fmt.Errorf("failed to open embedding cache: %w", err)
+		}
+		client.cache = cache
+	}
+
+	go client.batchLoop()
+
+	// Forward stderr to our stderr
+	go io.Copy(os.Stderr, process.Stderr)
+
+	// Wait for ready signal
+	resp, err := client.readResponse()
+	if err != nil {
+		process.Terminate()
+		return nil, fmt.Errorf("failed to get ready signal: %w", err)
+	}
+	if resp.Status != "ready" {
+		process.Terminate()
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	client.model = resp.Model
+	fmt.Printf("Embedding service ready (model: %s)\n", resp.Model)
+
+	// Only now start routing responses by id; the ready handshake above
+	// is read directly since it arrives before any id is assigned.
+	go client.readLoop()
+
+	// Load specific model if requested
+	if modelName != "" && modelName != resp.Model {
+		if err := client.LoadModel(modelName); err != nil {
+			process.Terminate()
+			return nil, err
+		}
+	}
+
+	return client, nil
+}
+
+func (c *EmbedClient) sendCommand(cmd map[string]interface{}) error {
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+	_, err = c.writer.Write(append(data, '\n'))
+	return err
+}
+
+func (c *EmbedClient) readResponse() (*EmbedResponse, error) {
+	line, err := c.reader.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	var resp EmbedResponse
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("python error: %s", resp.Error)
+	}
+	return &resp, nil
+}
+
+// LoadModel switches to a different embedding model
+func (c *EmbedClient) LoadModel(name string) error {
+	return c.LoadModelContext(context.Background(), name)
+}
+
+// LoadModelContext is LoadModel with cancellation: ctx's deadline or
+// cancellation aborts the in-flight load instead of blocking forever.
+func (c *EmbedClient) LoadModelContext(ctx context.Context, name string) error {
+	start := time.Now()
+	resp, err := c.call(ctx, map[string]interface{}{"action": "load", "model": name})
+	if err != nil {
+		return err
+	}
+	modelLoadSeconds.Observe(time.Since(start).Seconds())
+	c.model = resp.Model
+	c.dim = resp.Dimension
+	fmt.Printf("Loaded model: %s (dimension: %d)\n", c.model, c.dim)
+	return nil
+}
+
+// SetRerankModel sets the sentence-transformers CrossEncoder used by
+// Rerank/RerankContext. It takes effect on the next rerank call, which
+// lazy-loads it in the Python subprocess; pass "" to use Python's default.
+func (c *EmbedClient) SetRerankModel(name string) {
+	c.rerankModel = name
+}
+
+// Rerank scores docs against query with a CrossEncoder and returns hits
+// sorted by descending score, truncated to topK (0 means no truncation).
+// It uses the server-wide rerank model set by SetRerankModel.
+func (c *EmbedClient) Rerank(query string, docs []string, topK int) ([]RerankHit, error) {
+	return c.RerankContext(context.Background(), query, docs, topK, "")
+}
+
+// RerankContext is Rerank with cancellation and a per-call model override;
+// an empty model falls back to the one set by SetRerankModel.
+func (c *EmbedClient) RerankContext(ctx context.Context, query string, docs []string, topK int, model string) ([]RerankHit, error) {
+	if model == "" {
+		model = c.rerankModel
+	}
+	resp, err := c.call(ctx, map[string]interface{}{
+		"action": "rerank",
+		"query":  query,
+		"docs":   docs,
+		"top_k":  topK,
+		"model":  model,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Hits, nil
+}
+
+// ClientInfo is the current model info together with micro-batching stats.
+type ClientInfo struct {
+	EmbedResponse
+	Batch BatchStats `json:"batch"`
+}
+
+// Info returns current model info plus batching stats
+func (c *EmbedClient) Info() (*ClientInfo, error) {
+	return c.InfoContext(context.Background())
+}
+
+// InfoContext is Info with cancellation.
+func (c *EmbedClient) InfoContext(ctx context.Context) (*ClientInfo, error) {
+	resp, err := c.call(ctx, map[string]interface{}{"action": "info"})
+	if err != nil {
+		return nil, err
+	}
+	return &ClientInfo{EmbedResponse: *resp, Batch: c.BatchStats()}, nil
+}
+
+// CacheStats returns the embedding cache's hit/miss stats, or a zero value
+// if caching is disabled.
+func (c *EmbedClient) CacheStats() CacheStats {
+	if c.cache == nil {
+		return CacheStats{}
+	}
+	return c.cache.Stats()
+}
+
+// Close terminates the Python process
+func (c *EmbedClient) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sendCommand(map[string]interface{}{"action": "exit"})
+	c.process.Wait()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus metrics, registered against the default registry and scraped
+// at /metrics (see runServer in server.go).
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "jb_embed_requests_total",
+		Help: "Total HTTP requests, by endpoint, model and status.",
+	}, []string{"endpoint", "model", "status"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "jb_embed_request_duration_seconds",
+		Help:    "HTTP request handling latency in seconds.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"endpoint"})
+
+	batchSizeHistogram = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "jb_embed_batch_size",
+		Help:    "Number of texts per batched Python embed call.",
+		Buckets: []float64{1, 2, 4, 8, 16, 32, 64, 128},
+	})
+
+	textsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "jb_embed_texts_total",
+		Help: "Total texts embedded.",
+	})
+
+	queueDepthGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "jb_embed_queue_depth",
+		Help: "Current depth of the micro-batch queue.",
+	})
+
+	pythonRestartsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "jb_embed_python_restarts_total",
+		Help: "Total times the Python subprocess was restarted after becoming unresponsive.",
+	})
+
+	modelLoadSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "jb_embed_model_load_seconds",
+		Help:    "Time taken to load a sentence-transformers model.",
+		Buckets: []float64{.1, .5, 1, 2, 5, 10, 30, 60},
+	})
+
+	cacheHitsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "jb_embed_cache_hits_total",
+		Help: "Total embedding cache hits.",
+	})
+
+	cacheMissesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "jb_embed_cache_misses_total",
+		Help: "Total embedding cache misses.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		requestsTotal,
+		requestDuration,
+		batchSizeHistogram,
+		textsTotal,
+		queueDepthGauge,
+		pythonRestartsTotal,
+		modelLoadSeconds,
+		cacheHitsTotal,
+		cacheMissesTotal,
+	)
+}
+
+// metricsHandler serves the Prometheus text exposition format at /metrics.
+func metricsHandler() http.Handler {
+	return promhttp.Handler()
+}
+
+// newLogger builds the structured logger used for per-request log lines.
+// format is "json" (log/slog's JSON handler, for Loki/ELK) or "text".
+func newLogger(format string) *slog.Logger {
+	opts := &slog.HandlerOptions{}
+	var handler slog.Handler
+	if format == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	}
+	return slog.New(handler)
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ModelRegistry maps OpenAI-style model aliases (e.g. "text-embedding-ada-002")
+// to the sentence-transformers model name jb-embed should actually load.
+type ModelRegistry struct {
+	aliases map[string]string
+	created int64
+}
+
+// NewModelRegistry builds a registry from alias=model pairs, always including
+// defaultModel under its own name so it is resolvable even with no aliases.
+func NewModelRegistry(defaultModel string, pairs map[string]string) *ModelRegistry {
+	aliases := make(map[string]string, len(pairs)+1)
+	for alias, model := range pairs {
+		aliases[alias] = model
+	}
+	if _, ok := aliases[defaultModel]; !ok {
+		aliases[defaultModel] = defaultModel
+	}
+	return &ModelRegistry{aliases: aliases, created: time.Now().Unix()}
+}
+
+// Resolve maps a requested model id to the underlying sentence-transformers
+// name. Unknown ids fall through unchanged so callers can still name a model
+// directly.
+func (r *ModelRegistry) Resolve(id string) string {
+	if name, ok := r.aliases[id]; ok {
+		return name
+	}
+	return id
+}
+
+// IDs returns the registry's model ids in no particular order (map
+// iteration order).
+func (r *ModelRegistry) IDs() []string {
+	ids := make([]string, 0, len(r.aliases))
+	for alias := range r.aliases {
+		ids = append(ids, alias)
+	}
+	return ids
+}
+
+// Has reports whether id is a known alias.
+func (r *ModelRegistry) Has(id string) bool {
+	_, ok := r.aliases[id]
+	return ok
+}
+
+// openAIModel is the OpenAI model-list JSON schema.
+type openAIModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+type openAIEmbeddingRequest struct {
+	Model          string      `json:"model"`
+	Input          interface{} `json:"input"`
+	EncodingFormat string      `json:"encoding_format"`
+	User           string      `json:"user"`
+}
+
+type openAIEmbeddingData struct {
+	Object    string      `json:"object"`
+	Index     int         `json:"index"`
+	Embedding interface{} `json:"embedding"`
+}
+
+type openAIUsage struct {
+	PromptTokens int `json:"prompt_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+type openAIEmbeddingResponse struct {
+	Object string                `json:"object"`
+	Data   []openAIEmbeddingData `json:"data"`
+	Model  string                `json:"model"`
+	Usage  openAIUsage           `json:"usage"`
+}
+
+// openAIInputTexts normalizes the `input` field, which per the OpenAI schema
+// may be a single string, an array of strings, or an array of token-id
+// arrays. Token-id inputs aren't meaningful without the original tokenizer,
+// so they're rendered back into a whitespace-joined string.
+func openAIInputTexts(input interface{}) ([]string, error) {
+	switch v := input.(type) {
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		texts := make([]string, len(v))
+		for i, item := range v {
+			switch e := item.(type) {
+			case string:
+				texts[i] = e
+			case []interface{}:
+				tokens := make([]string, len(e))
+				for j, t := range e {
+					if n, ok := t.(float64); ok {
+						tokens[j] = strconv.FormatInt(int64(n), 10)
+					}
+				}
+				texts[i] = strings.Join(tokens, " ")
+			default:
+				return nil, httpError{status: http.StatusBadRequest, msg: "input must be a string, array of strings, or array of token arrays"}
+			}
+		}
+		return texts, nil
+	default:
+		return nil, httpError{status: http.StatusBadRequest, msg: "input is required"}
+	}
+}
+
+// httpError carries an HTTP status alongside a message for handlers that
+// need to distinguish client errors from internal ones.
+type httpError struct {
+	status int
+	msg    string
+}
+
+func (e httpError) Error() string { return e.msg }
+
+// estimateTokens gives a rough prompt-token count in the absence of the
+// model's real tokenizer, using the common ~4-chars-per-token heuristic.
+func estimateTokens(text string) int {
+	n := len(strings.TrimSpace(text))
+	if n == 0 {
+		return 0
+	}
+	tokens := n / 4
+	if tokens == 0 {
+		tokens = 1
+	}
+	return tokens
+}
+
+// encodeBase64Embedding little-endian packs a float32 embedding, matching
+// the OpenAI `encoding_format=base64` wire format.
+func encodeBase64Embedding(vec []float64) string {
+	buf := make([]byte, 4*len(vec))
+	for i, v := range vec {
+		binary.LittleEndian.PutUint32(buf[i*4:], math.Float32bits(float32(v)))
+	}
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+func writeOpenAIError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": message,
+			"type":    "invalid_request_error",
+		},
+	})
+}
+
+func (s *Server) handleOpenAIEmbeddings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req openAIEmbeddingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	texts, err := openAIInputTexts(req.Input)
+	if err != nil {
+		if he, ok := err.(httpError); ok {
+			writeOpenAIError(w, he.status, he.msg)
+			return
+		}
+		writeOpenAIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(texts) == 0 {
+		writeOpenAIError(w, http.StatusBadRequest, "input is required")
+		return
+	}
+
+	ctx, cancel := s.contextFor(r)
+	defer cancel()
+
+	modelName := s.registry.Resolve(req.Model)
+
+	// Only the check-then-load has to be a critical section: there's a single
+	// subprocess with one active model, so without the lock two requests
+	// naming different models can race and one's embed call runs against
+	// whichever model the other just loaded. Once the model is confirmed
+	// correct, the lock is released before EmbedContext so same-model
+	// requests still batch and run concurrently instead of serializing on
+	// every /v1/embeddings call.
+	s.modelMu.Lock()
+	start := time.Now()
+	if s.client.model != modelName {
+		if err := s.client.LoadModelContext(ctx, modelName); err != nil {
+			s.modelMu.Unlock()
+			writeOpenAIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	s.modelMu.Unlock()
+
+	embeddings, err := s.client.EmbedContext(ctx, texts)
+	s.observeRequest("/v1/embeddings", modelName, len(texts), start, err)
+	if err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	data := make([]openAIEmbeddingData, len(embeddings))
+	promptTokens := 0
+	for i, emb := range embeddings {
+		promptTokens += estimateTokens(texts[i])
+		var encoded interface{} = emb
+		if req.EncodingFormat == "base64" {
+			encoded = encodeBase64Embedding(emb)
+		}
+		data[i] = openAIEmbeddingData{Object: "embedding", Index: i, Embedding: encoded}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(openAIEmbeddingResponse{
+		Object: "list",
+		Data:   data,
+		Model:  req.Model,
+		Usage:  openAIUsage{PromptTokens: promptTokens, TotalTokens: promptTokens},
+	})
+}
+
+// openAIRerankRequest is the Cohere/Voyage-style rerank request shape.
+type openAIRerankRequest struct {
+	Model     string   `json:"model"`
+	Query     string   `json:"query"`
+	Documents []string `json:"documents"`
+	TopN      int      `json:"top_n"`
+}
+
+type openAIRerankResult struct {
+	Index          int     `json:"index"`
+	RelevanceScore float64 `json:"relevance_score"`
+}
+
+type openAIRerankResponse struct {
+	Results []openAIRerankResult `json:"results"`
+	Model   string               `json:"model"`
+}
+
+func (s *Server) handleOpenAIRerank(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req openAIRerankRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(req.Documents) == 0 {
+		writeOpenAIError(w, http.StatusBadRequest, "documents is required")
+		return
+	}
+
+	ctx, cancel := s.contextFor(r)
+	defer cancel()
+
+	start := time.Now()
+	hits, err := s.client.RerankContext(ctx, req.Query, req.Documents, req.TopN, req.Model)
+	s.observeRequest("/v1/rerank", req.Model, len(req.Documents), start, err)
+	if err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	results := make([]openAIRerankResult, len(hits))
+	for i, hit := range hits {
+		results[i] = openAIRerankResult{Index: hit.Index, RelevanceScore: hit.Score}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(openAIRerankResponse{Results: results, Model: req.Model})
+}
+
+func (s *Server) handleOpenAIModels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	models := make([]openAIModel, 0, len(s.registry.IDs()))
+	for _, id := range s.registry.IDs() {
+		models = append(models, openAIModel{ID: id, Object: "model", Created: s.registry.created, OwnedBy: "jb-embed"})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"object": "list",
+		"data":   models,
+	})
+}
+
+func (s *Server) handleOpenAIModelByID(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/v1/models/")
+	if id == "" || !s.registry.Has(id) {
+		writeOpenAIError(w, http.StatusNotFound, "model not found: "+id)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(openAIModel{ID: id, Object: "model", Created: s.registry.created, OwnedBy: "jb-embed"})
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// cancelGrace is how long call() waits for Python to acknowledge a cancel
+// message before concluding the subprocess is stuck and restarting it.
+const cancelGrace = 2 * time.Second
+
+// readLoop continuously reads Python responses and delivers each one to the
+// pending call waiting on its id. It runs for the lifetime of the
+// subprocess; restart() starts a fresh one after replacing the process.
+func (c *EmbedClient) readLoop() {
+	for {
+		line, err := c.reader.ReadBytes('\n')
+		if err != nil {
+			c.failAllPending(fmt.Errorf("embed subprocess pipe closed: %w", err))
+			return
+		}
+		var resp EmbedResponse
+		if err := json.Unmarshal(line, &resp); err != nil {
+			continue
+		}
+		c.deliver(resp.ID, &resp)
+	}
+}
+
+func (c *EmbedClient) deliver(id uint64, resp *EmbedResponse) {
+	c.pendingMu.Lock()
+	ch, ok := c.pending[id]
+	if ok {
+		delete(c.pending, id)
+	}
+	c.pendingMu.Unlock()
+	if ok {
+		ch <- resp
+	}
+}
+
+func (c *EmbedClient) failAllPending(err error) {
+	c.pendingMu.Lock()
+	pending := c.pending
+	c.pending = make(map[uint64]chan *EmbedResponse)
+	c.pendingMu.Unlock()
+
+	for _, ch := range pending {
+		ch <- &EmbedResponse{Error: err.Error()}
+	}
+}
+
+// call issues cmd (tagged with a fresh request id) and waits for its
+// response. The wait can be aborted early by ctx's deadline or cancellation,
+// in which case Python is told to drop the request via abort.
+func (c *EmbedClient) call(ctx context.Context, cmd map[string]interface{}) (*EmbedResponse, error) {
+	id := atomic.AddUint64(&c.nextID, 1)
+	cmd["id"] = id
+
+	respCh := make(chan *EmbedResponse, 1)
+	c.pendingMu.Lock()
+	c.pending[id] = respCh
+	c.pendingMu.Unlock()
+
+	// Mirrors the deadline-timer pattern netstack uses for pipes that have
+	// no native I/O deadline: a timer fires a cancellation signal rather
+	// than the OS cancelling the read for us.
+	cancelCh := make(chan struct{})
+	if deadline, ok := ctx.Deadline(); ok {
+		timer := time.AfterFunc(time.Until(deadline), func() { close(cancelCh) })
+		defer timer.Stop()
+	}
+
+	c.mu.Lock()
+	err := c.sendCommand(cmd)
+	c.mu.Unlock()
+	if err != nil {
+		c.pendingMu.Lock()
+		delete(c.pending, id)
+		c.pendingMu.Unlock()
+		return nil, err
+	}
+
+	select {
+	case resp := <-respCh:
+		if resp.Error != "" {
+			return nil, fmt.Errorf("python error: %s", resp.Error)
+		}
+		return resp, nil
+	case <-cancelCh:
+		return nil, c.abort(id, respCh, context.DeadlineExceeded)
+	case <-ctx.Done():
+		return nil, c.abort(id, respCh, ctx.Err())
+	}
+}
+
+// abort tells Python to drop request id and waits up to cancelGrace for an
+// acknowledgment. If none arrives, the pipe is presumed stuck and the
+// subprocess is restarted rather than leaking it.
+func (c *EmbedClient) abort(id uint64, respCh chan *EmbedResponse, reason error) error {
+	c.mu.Lock()
+	c.sendCommand(map[string]interface{}{"action": "cancel", "id": id})
+	c.mu.Unlock()
+
+	select {
+	case <-respCh:
+	case <-time.After(cancelGrace):
+		c.restart()
+	}
+
+	c.pendingMu.Lock()
+	delete(c.pending, id)
+	c.pendingMu.Unlock()
+
+	return reason
+}
+
+// restart terminates an unresponsive subprocess and replaces it with a
+// fresh one, reloading whatever model was last active. Any other calls
+// still waiting on the old pipe are failed by failAllPending once the old
+// readLoop hits EOF after the Terminate below.
+func (c *EmbedClient) restart() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	log.Printf("embed subprocess unresponsive, restarting")
+	pythonRestartsTotal.Inc()
+	c.process.Terminate()
+
+	process, _, err := c.env.NewPythonProcessFromProgram(c.program, nil, nil, false)
+	if err != nil {
+		log.Printf("failed to restart embed subprocess: %v", err)
+		return
+	}
+
+	c.process = process
+	c.reader = bufio.NewReader(process.PipeIn)
+	c.writer = process.PipeOut
+	go io.Copy(os.Stderr, process.Stderr)
+
+	resp, err := c.readResponse()
+	if err != nil {
+		log.Printf("embed subprocess restarted but never signalled ready: %v", err)
+		return
+	}
+
+	go c.readLoop()
+
+	if c.model != "" && c.model != resp.Model {
+		c.sendCommand(map[string]interface{}{"action": "load", "model": c.model, "id": atomic.AddUint64(&c.nextID, 1)})
+	} else {
+		c.model = resp.Model
+	}
+}
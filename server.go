@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Server wraps an EmbedClient with the native and OpenAI-compatible HTTP APIs.
+type Server struct {
+	client         *EmbedClient
+	apiKey         string
+	registry       *ModelRegistry
+	requestTimeout time.Duration
+	logger         *slog.Logger
+	reqIDCounter   int64
+	stats          struct {
+		requests int64
+		start    time.Time
+	}
+
+	// modelMu serializes model switches: handleOpenAIEmbeddings runs its
+	// check-then-load under it and handleModel its load, so two loads never
+	// overlap on the single Python subprocess. The embed that follows a load
+	// in handleOpenAIEmbeddings runs after modelMu has been released.
+	modelMu sync.Mutex
+}
+
+// contextFor derives a request context bounded by s.requestTimeout (if set)
+// from r, so client disconnects and per-request deadlines both cancel
+// in-flight embedding work.
+func (s *Server) contextFor(r *http.Request) (context.Context, context.CancelFunc) {
+	if s.requestTimeout > 0 {
+		return context.WithTimeout(r.Context(), s.requestTimeout)
+	}
+	return r.Context(), func() {}
+}
+
+// observeRequest records the Prometheus request metrics and emits a
+// structured log line for one handled request.
+func (s *Server) observeRequest(endpoint, model string, nTexts int, start time.Time, err error) {
+	dur := time.Since(start)
+	status := "success"
+	if err != nil {
+		status = "error"
+	}
+	requestsTotal.WithLabelValues(endpoint, model, status).Inc()
+	requestDuration.WithLabelValues(endpoint).Observe(dur.Seconds())
+	atomic.AddInt64(&s.stats.requests, 1)
+
+	reqID := atomic.AddInt64(&s.reqIDCounter, 1)
+	if err != nil {
+		s.logger.Error("request", "req_id", reqID, "endpoint", endpoint, "model", model, "n_texts", nTexts, "duration_ms", dur.Milliseconds(), "err", err.Error())
+		return
+	}
+	s.logger.Info("request", "req_id", reqID, "endpoint", endpoint, "model", model, "n_texts", nTexts, "duration_ms", dur.Milliseconds())
+}
+
+func (s *Server) handleEmbed(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Text  string   `json:"text"`
+		Texts []string `json:"texts"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	texts := req.Texts
+	if req.Text != "" {
+		texts = append(texts, req.Text)
+	}
+	if len(texts) == 0 {
+		http.Error(w, "no texts provided", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := s.contextFor(r)
+	defer cancel()
+
+	start := time.Now()
+	embeddings, err := s.client.EmbedContext(ctx, texts)
+	s.observeRequest("/embed", s.client.model, len(texts), start, err)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"embeddings": embeddings,
+		"model":      s.client.model,
+		"dimension":  len(embeddings[0]),
+	})
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.contextFor(r)
+	defer cancel()
+
+	info, err := s.client.InfoContext(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    "healthy",
+		"model":     info.Model,
+		"dimension": info.Dimension,
+		"uptime":    time.Since(s.stats.start).String(),
+		"requests":  atomic.LoadInt64(&s.stats.requests),
+		"batch":     info.Batch,
+		"cache":     s.client.CacheStats(),
+	})
+}
+
+func (s *Server) handleModel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Model string `json:"model"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := s.contextFor(r)
+	defer cancel()
+
+	s.modelMu.Lock()
+	start := time.Now()
+	err := s.client.LoadModelContext(ctx, req.Model)
+	s.modelMu.Unlock()
+	s.observeRequest("/model", req.Model, 0, start, err)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "ok",
+		"model":  s.client.model,
+	})
+}
+
+// cosineSimilarity computes cosine similarity between two equal-length
+// embeddings, returning 0 for a zero vector rather than dividing by zero.
+func cosineSimilarity(a, b []float64) float64 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// handleSimilarity computes cosine similarity server-side, saving clients an
+// embed round-trip and avoiding floating-point drift from re-implementing
+// the comparison themselves. It accepts either {a, b} for a single pair or
+// {query, candidates} to score several candidates against one query.
+func (s *Server) handleSimilarity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		A          string   `json:"a"`
+		B          string   `json:"b"`
+		Query      string   `json:"query"`
+		Candidates []string `json:"candidates"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := s.contextFor(r)
+	defer cancel()
+
+	var texts []string
+	switch {
+	case req.Query != "" && len(req.Candidates) > 0:
+		texts = append([]string{req.Query}, req.Candidates...)
+	case req.A != "" && req.B != "":
+		texts = []string{req.A, req.B}
+	default:
+		http.Error(w, "provide either {a, b} or {query, candidates}", http.StatusBadRequest)
+		return
+	}
+
+	start := time.Now()
+	embeddings, err := s.client.EmbedContext(ctx, texts)
+	s.observeRequest("/similarity", s.client.model, len(texts), start, err)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if req.Query != "" && len(req.Candidates) > 0 {
+		scores := make([]float64, len(req.Candidates))
+		for i := range req.Candidates {
+			scores[i] = cosineSimilarity(embeddings[0], embeddings[i+1])
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"scores": scores})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"score": cosineSimilarity(embeddings[0], embeddings[1])})
+}
+
+// requireAPIKey enforces "Authorization: Bearer <key>" when an API key is configured.
+func (s *Server) requireAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.apiKey == "" {
+			next(w, r)
+			return
+		}
+		auth := r.Header.Get("Authorization")
+		if auth != "Bearer "+s.apiKey {
+			http.Error(w, `{"error":{"message":"invalid api key","type":"invalid_request_error"}}`, http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// withCORS adds permissive CORS headers and answers preflight requests.
+func withCORS(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func runServer(client *EmbedClient, addr string, apiKey string, registry *ModelRegistry, requestTimeout time.Duration, logFormat string) {
+	server := &Server{client: client, apiKey: apiKey, registry: registry, requestTimeout: requestTimeout, logger: newLogger(logFormat)}
+	server.stats.start = time.Now()
+
+	http.HandleFunc("/embed", withCORS(server.handleEmbed))
+	http.HandleFunc("/health", withCORS(server.handleHealth))
+	http.HandleFunc("/model", withCORS(server.handleModel))
+	http.HandleFunc("/v1/embeddings", withCORS(server.requireAPIKey(server.handleOpenAIEmbeddings)))
+	http.HandleFunc("/v1/models", withCORS(server.requireAPIKey(server.handleOpenAIModels)))
+	http.HandleFunc("/v1/models/", withCORS(server.requireAPIKey(server.handleOpenAIModelByID)))
+	http.HandleFunc("/similarity", withCORS(server.handleSimilarity))
+	http.HandleFunc("/v1/rerank", withCORS(server.requireAPIKey(server.handleOpenAIRerank)))
+	http.Handle("/metrics", metricsHandler())
+
+	fmt.Printf("Starting server on %s\n", addr)
+	fmt.Println("Endpoints:")
+	fmt.Println("  POST /embed          - Generate embeddings")
+	fmt.Println("  GET  /health         - Health check")
+	fmt.Println("  POST /model          - Switch model")
+	fmt.Println("  POST /v1/embeddings  - OpenAI-compatible embeddings")
+	fmt.Println("  GET  /v1/models      - OpenAI-compatible model list")
+	fmt.Println("  POST /similarity     - Cosine similarity between texts")
+	fmt.Println("  POST /v1/rerank      - Cohere/Voyage-compatible reranking")
+	fmt.Println("  GET  /metrics        - Prometheus metrics")
+	if apiKey != "" {
+		fmt.Println("  (API key required via Authorization: Bearer <key> on /v1/* routes)")
+	}
+
+	log.Fatal(http.ListenAndServe(addr, nil))
+}